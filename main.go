@@ -6,16 +6,137 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"text/template"
+	"text/template/parse"
+	"time"
 )
 
 type config struct {
-	ID         string // Identifier for the tags to inject content between
-	failOnDiff bool   // If true, exits with code 2 if file would be changed
-	printOnly  bool   // If true, prints output to stdout instead of writing to file
-	template   string // Go template to apply to stdin before injecting
-	filename   string // Target file to modify
+	ID         string       // Identifier for the tags to inject content between
+	failOnDiff bool         // If true, exits with code 2 if file would be changed
+	printOnly  bool         // If true, prints output to stdout instead of writing to file
+	template   string       // Go template to apply to stdin before injecting
+	filename   string       // Target file to modify
+	configFile string       // Path to a batch config describing multiple entries, if set
+	vars       templateVars // User-supplied --var key=value pairs, exposed as .var in templates
+
+	sourceCmd       string // Shell command whose stdout replaces stdin as the content to inject
+	sourceFile      string // File whose contents replace stdin as the content to inject
+	allowSourceFail bool   // If true, a non-zero --source-cmd exit still proceeds with its stdout so far
+	include         string // Markdown file to splice in after the resolved content
+	includeShift    int    // Heading levels to shift the --include content by, e.g. 1 turns "#" into "##"
+
+	commentStyle string // Named tag style (see commentStyles), auto-detected from the file extension when empty
+	tagFormat    string // Escape hatch: explicit "STARTFORMAT|ENDFORMAT" pair, overrides commentStyle
+
+	diff bool // If true and --fail-on-diff trips, print a unified diff of the pending change to stderr before exiting
+}
+
+// tagPair is the pair of start/end tags content gets injected between.
+type tagPair struct {
+	Start string
+	End   string
+}
+
+// commentStyle is a start/end tag format pair, with "%s" standing in for the
+// injection ID.
+type commentStyle struct {
+	startFormat string
+	endFormat   string
+}
+
+// commentStyles are the built-in --comment-style presets.
+var commentStyles = map[string]commentStyle{
+	"html":      {tagStartFormat, tagEndFormat},
+	"hash":      {"# START md-inject:%s", "# END md-inject:%s"},
+	"slash":     {"// START md-inject:%s", "// END md-inject:%s"},
+	"slashstar": {"/* START md-inject:%s */", "/* END md-inject:%s */"},
+	"semicolon": {"; START md-inject:%s", "; END md-inject:%s"},
+}
+
+// extCommentStyles maps file extensions to a default --comment-style, used
+// when --comment-style and --tag-format are both unset.
+var extCommentStyles = map[string]string{
+	".md":       "html",
+	".markdown": "html",
+	".yaml":     "hash",
+	".yml":      "hash",
+	".sh":       "hash",
+	".bash":     "hash",
+	".py":       "hash",
+	".rb":       "hash",
+	".tf":       "hash",
+	".go":       "slash",
+	".js":       "slash",
+	".ts":       "slash",
+	".java":     "slash",
+	".c":        "slashstar",
+	".h":        "slashstar",
+	".css":      "slashstar",
+	".sql":      "semicolon",
+	".ini":      "semicolon",
+}
+
+// resolveTagPair determines the start/end tags for id and filename, in order
+// of precedence: --tag-format, --comment-style, then auto-detection from the
+// file extension, falling back to the original HTML comment style.
+func resolveTagPair(cfg *config, id, filename string) (tagPair, error) {
+	if cfg.tagFormat != "" {
+		parts := strings.SplitN(cfg.tagFormat, "|", 2)
+		if len(parts) != 2 || !strings.Contains(parts[0], "%s") || !strings.Contains(parts[1], "%s") {
+			return tagPair{}, fmt.Errorf("--tag-format must be \"STARTFORMAT|ENDFORMAT\" with a %%s placeholder in each half, got %q", cfg.tagFormat)
+		}
+		return tagPair{Start: fmt.Sprintf(parts[0], id), End: fmt.Sprintf(parts[1], id)}, nil
+	}
+
+	style := cfg.commentStyle
+	if style == "" {
+		style = extCommentStyles[strings.ToLower(filepath.Ext(filename))]
+	}
+	if style == "" {
+		style = "html"
+	}
+
+	cs, ok := commentStyles[style]
+	if !ok {
+		return tagPair{}, fmt.Errorf("unknown --comment-style %q", style)
+	}
+
+	return tagPair{Start: fmt.Sprintf(cs.startFormat, id), End: fmt.Sprintf(cs.endFormat, id)}, nil
+}
+
+// templateVars holds repeatable --var key=value flags and is exposed to
+// templates as .var.KEY.
+type templateVars map[string]string
+
+func (v templateVars) String() string {
+	parts := make([]string, 0, len(v))
+	for k, val := range v {
+		parts = append(parts, k+"="+val)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (v templateVars) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	v[key] = value
+	return nil
+}
+
+// batchEntry describes a single injection to run as part of a --config batch.
+type batchEntry struct {
+	ID       string // Identifier for the tags to inject content between, defaults to defaultTagID
+	File     string // Target file to modify
+	Command  string // Shell command whose stdout becomes the injected content
+	Template string // Go template to apply, defaults to cfg.template when empty
 }
 
 const (
@@ -29,10 +150,30 @@ func main() {
 	flag.Usage = usage
 	cfg := parseArgs()
 
+	if cfg.configFile != "" {
+		code, err := runBatch(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		os.Exit(code)
+	}
+
+	runSingle(cfg)
+}
+
+// runSingle implements the original single-file pipeline, now fed by stdin,
+// --source-cmd, or --source-file, optionally extended with --include.
+func runSingle(cfg *config) {
 	// read content to be injected
-	contentToInject, err := readStdin()
+	contentToInject, err := resolveContent(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading source: %v\n", err)
+		os.Exit(1)
+	}
+
+	contentToInject, err = applyInclude(cfg, contentToInject)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error applying --include: %v\n", err)
 		os.Exit(1)
 	}
 
@@ -44,16 +185,24 @@ func main() {
 	}
 
 	// apply a template to the content to be injected
-	contentToInject, err = applyTemplate(cfg.template, contentToInject)
+	data, err := buildTemplateContext(cfg, cfg.template, cfg.filename, contentToInject)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error building template context: %v\n", err)
+		os.Exit(1)
+	}
+	contentToInject, err = applyTemplate(cfg.template, data)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error applying template: %v\n", err)
 		os.Exit(1)
 	}
 
 	// inject new content
-	startTag := fmt.Sprintf(tagStartFormat, cfg.ID)
-	endTag := fmt.Sprintf(tagEndFormat, cfg.ID)
-	updatedContent, err := injectContent(oldContent, contentToInject, startTag, endTag)
+	tags, err := resolveTagPair(cfg, cfg.ID, cfg.filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	updatedContent, err := injectContent(oldContent, contentToInject, tags)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -67,6 +216,9 @@ func main() {
 
 	// fail if --fail-on-diff is set
 	if cfg.failOnDiff {
+		if cfg.diff {
+			fmt.Fprint(os.Stderr, unifiedDiff(cfg.filename, oldContent, updatedContent))
+		}
 		fmt.Fprintf(os.Stderr, "%s would be changed. The file is out of date.\n", cfg.filename)
 		os.Exit(2)
 	}
@@ -87,16 +239,254 @@ func main() {
 	fmt.Printf("%s successfully updated!\n", cfg.filename)
 }
 
+// runBatch processes every entry in cfg.configFile, running independent
+// entries concurrently while serializing entries that target the same file.
+// It returns the process exit code to use and, if non-nil, an error
+// describing what went wrong (aggregated across all failing entries).
+func runBatch(cfg *config) (int, error) {
+	entries, err := parseConfigFile(cfg.configFile)
+	if err != nil {
+		return 1, err
+	}
+
+	type result struct {
+		entry   batchEntry
+		changed bool
+		err     error
+	}
+
+	results := make([]result, len(entries))
+	locks := fileLocks(entries)
+
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		wg.Add(1)
+		go func(i int, entry batchEntry) {
+			defer wg.Done()
+
+			lock := locks[entry.File]
+			lock.Lock()
+			defer lock.Unlock()
+
+			changed, err := processEntry(cfg, entry)
+			results[i] = result{entry: entry, changed: changed, err: err}
+		}(i, entry)
+	}
+	wg.Wait()
+
+	var errs []string
+	anyChanged := false
+	for _, r := range results {
+		if r.err != nil {
+			errs = append(errs, fmt.Sprintf("%s (id=%s): %v", r.entry.File, r.entry.ID, r.err))
+			continue
+		}
+		if r.changed {
+			anyChanged = true
+		}
+	}
+
+	if len(errs) > 0 {
+		return 1, fmt.Errorf("%d of %d entries failed:\n%s", len(errs), len(entries), strings.Join(errs, "\n"))
+	}
+
+	if cfg.failOnDiff && anyChanged {
+		return 2, fmt.Errorf("one or more files would be changed, the tree is out of date")
+	}
+
+	return 0, nil
+}
+
+// fileLocks returns one mutex per distinct target file referenced by entries,
+// so that two entries writing to the same file never race.
+func fileLocks(entries []batchEntry) map[string]*sync.Mutex {
+	locks := make(map[string]*sync.Mutex)
+	for _, e := range entries {
+		if _, ok := locks[e.File]; !ok {
+			locks[e.File] = &sync.Mutex{}
+		}
+	}
+	return locks
+}
+
+// processEntry runs a single batch entry end-to-end and reports whether the
+// target file changed (or would change, under --fail-on-diff).
+func processEntry(cfg *config, entry batchEntry) (bool, error) {
+	contentToInject, err := runCommand(entry.Command)
+	if err != nil {
+		return false, fmt.Errorf("running command: %w", err)
+	}
+
+	oldContent, err := fileContent(entry.File)
+	if err != nil {
+		return false, fmt.Errorf("reading file: %w", err)
+	}
+
+	tmpl := entry.Template
+	if tmpl == "" {
+		tmpl = cfg.template
+	}
+	data, err := buildTemplateContext(cfg, tmpl, entry.File, contentToInject)
+	if err != nil {
+		return false, fmt.Errorf("building template context: %w", err)
+	}
+	contentToInject, err = applyTemplate(tmpl, data)
+	if err != nil {
+		return false, fmt.Errorf("applying template: %w", err)
+	}
+
+	id := entry.ID
+	if id == "" {
+		id = defaultTagID
+	}
+	tags, err := resolveTagPair(cfg, id, entry.File)
+	if err != nil {
+		return false, err
+	}
+	updatedContent, err := injectContent(oldContent, contentToInject, tags)
+	if err != nil {
+		return false, err
+	}
+
+	if oldContent == updatedContent {
+		return false, nil
+	}
+
+	if cfg.failOnDiff {
+		if cfg.diff {
+			fmt.Fprint(os.Stderr, unifiedDiff(entry.File, oldContent, updatedContent))
+		}
+		return true, nil
+	}
+
+	if cfg.printOnly {
+		fmt.Print(updatedContent)
+		return true, nil
+	}
+
+	if err := os.WriteFile(entry.File, []byte(updatedContent), 0600); err != nil {
+		return false, fmt.Errorf("writing file: %w", err)
+	}
+	fmt.Printf("%s successfully updated! (id=%s)\n", entry.File, id)
+
+	return true, nil
+}
+
+// runCommand executes command through the shell and returns its stdout. On
+// failure it still returns whatever stdout was captured, alongside the
+// error, so callers like --allow-source-fail can use it.
+func runCommand(command string) (string, error) {
+	c := exec.Command("sh", "-c", command)
+	var out bytes.Buffer
+	c.Stdout = &out
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return out.String(), fmt.Errorf("command %q: %w", command, err)
+	}
+	return out.String(), nil
+}
+
+// parseConfigFile reads a --config batch file. The format is a minimal,
+// hand-rolled subset of YAML: a top-level list of flat "key: value" maps,
+// one entry per "- " item. It intentionally does not pull in a full
+// YAML/TOML parser; if the list layout below doesn't cover your case, the
+// single-file stdin pipeline is still the better fit.
+//
+//   - id: usage
+//     file: README.md
+//     command: go run ./cmd/foo --help
+//     template: '```\n{{ .stdin }}```'
+func parseConfigFile(path string) ([]batchEntry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+
+	var entries []batchEntry
+	var cur *batchEntry
+
+	for i, rawLine := range strings.Split(string(b), "\n") {
+		trimmed := strings.TrimSpace(rawLine)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "- ") {
+			if cur != nil {
+				entries = append(entries, *cur)
+			}
+			cur = &batchEntry{}
+			trimmed = strings.TrimPrefix(trimmed, "- ")
+		}
+
+		if cur == nil {
+			return nil, fmt.Errorf("config %s:%d: entry value outside of a list item", path, i+1)
+		}
+
+		key, value, ok := splitConfigKV(trimmed)
+		if !ok {
+			return nil, fmt.Errorf("config %s:%d: expected \"key: value\", got %q", path, i+1, trimmed)
+		}
+
+		switch key {
+		case "id":
+			cur.ID = value
+		case "file":
+			cur.File = value
+		case "command":
+			cur.Command = value
+		case "template":
+			cur.Template = value
+		default:
+			return nil, fmt.Errorf("config %s:%d: unknown key %q", path, i+1, key)
+		}
+	}
+	if cur != nil {
+		entries = append(entries, *cur)
+	}
+
+	for _, e := range entries {
+		if e.File == "" {
+			return nil, fmt.Errorf("config %s: entry %q is missing \"file\"", path, e.ID)
+		}
+		if e.Command == "" {
+			return nil, fmt.Errorf("config %s: entry %q is missing \"command\"", path, e.ID)
+		}
+	}
+
+	return entries, nil
+}
+
+// splitConfigKV splits a "key: value" line, trimming surrounding quotes from
+// the value.
+func splitConfigKV(s string) (key, value string, ok bool) {
+	idx := strings.Index(s, ":")
+	if idx < 0 {
+		return "", "", false
+	}
+	key = strings.TrimSpace(s[:idx])
+	value = strings.Trim(strings.TrimSpace(s[idx+1:]), `"'`)
+	return key, value, key != ""
+}
+
 func usage() {
 	fmt.Fprint(os.Stderr, `Inject text from stdin into markdown files and keep it up to date.
 
 Usage:
   md-inject [OPTIONS] FILE
+  md-inject --config md-inject.yaml
 
 Examples:
   $ cat foo.txt | md-inject README.md
   $ ./foo --help 2>&1 | md-inject --template='{{ printf "`+"```plaintext\\n%s```"+`" .stdin }}' readme.md
   $ ls -1 | md-inject --fail-on-diff readme.md
+  $ md-inject --config md-inject.yaml --fail-on-diff
+  $ ./foo --help 2>&1 | md-inject --var version=1.2.3 --template='{{ printf "Built from %s (v%s) on %s" .git.commit .var.version .now }}' readme.md
+  $ md-inject --source-cmd='go run ./cmd/foo --help' readme.md
+  $ md-inject --source-file CHANGELOG.md --include docs/footer.md --include-heading-shift 1 readme.md
+  $ terraform-docs . | md-inject --comment-style hash main.tf
+  $ terraform-docs . | md-inject --tag-format '# START inject:%s|# END inject:%s' main.tf
+  $ ls -1 | md-inject --fail-on-diff --diff readme.md
 
 Options:
 `)
@@ -110,8 +500,23 @@ func parseArgs() *config {
 	flag.BoolVar(&cfg.failOnDiff, "fail-on-diff", false, "set to true to get exit code 2 if the file would be changed")
 	flag.BoolVar(&cfg.printOnly, "print-only", false, "print the final output to stdout (this does not write anything to the file)")
 	flag.StringVar(&cfg.template, "template", defaultOutputTemplate, "Go template to apply to the stdin before injecting")
+	flag.StringVar(&cfg.configFile, "config", "", "path to a batch config file describing multiple injection entries to process in one pass, instead of reading a single file from stdin")
+	cfg.vars = templateVars{}
+	flag.Var(&cfg.vars, "var", "key=value pair exposed to templates as .var.KEY, repeatable")
+	flag.StringVar(&cfg.sourceCmd, "source-cmd", "", "shell command to run instead of reading stdin; its stdout becomes the content to inject")
+	flag.StringVar(&cfg.sourceFile, "source-file", "", "file to read instead of stdin or --source-cmd")
+	flag.BoolVar(&cfg.allowSourceFail, "allow-source-fail", false, "proceed with --source-cmd's stdout even if it exits non-zero")
+	flag.StringVar(&cfg.include, "include", "", "markdown file to splice in after the resolved content")
+	flag.IntVar(&cfg.includeShift, "include-heading-shift", 0, "heading levels to shift --include content by, e.g. 1 turns '#' into '##'")
+	flag.StringVar(&cfg.commentStyle, "comment-style", "", "named tag style: html, hash, slash, slashstar, or semicolon (default: auto-detected from the file extension, falling back to html)")
+	flag.StringVar(&cfg.tagFormat, "tag-format", "", `explicit "STARTFORMAT|ENDFORMAT" tag pair, each containing a %s for the id, overrides --comment-style`)
+	flag.BoolVar(&cfg.diff, "diff", false, "with --fail-on-diff, print a unified diff of the pending change to stderr before exiting")
 	flag.Parse()
 
+	if cfg.configFile != "" {
+		return cfg
+	}
+
 	args := flag.Args()
 	if len(args) < 1 {
 		fmt.Fprint(os.Stderr, "Error: Please specify a target file to inject text into, for example 'README.md'.\n\n")
@@ -123,6 +528,108 @@ func parseArgs() *config {
 	return cfg
 }
 
+// resolveContent returns the content to inject, preferring --source-cmd,
+// then --source-file, and falling back to stdin.
+func resolveContent(cfg *config) (string, error) {
+	switch {
+	case cfg.sourceCmd != "":
+		out, err := runCommand(cfg.sourceCmd)
+		if err != nil && !cfg.allowSourceFail {
+			return "", err
+		}
+		return out, nil
+	case cfg.sourceFile != "":
+		return fileContent(cfg.sourceFile)
+	default:
+		return readStdin()
+	}
+}
+
+// applyInclude splices cfg.include's contents after content, shifting its
+// heading levels by cfg.includeShift first. It's a no-op when --include
+// isn't set.
+func applyInclude(cfg *config, content string) (string, error) {
+	if cfg.include == "" {
+		return content, nil
+	}
+
+	included, err := fileContent(cfg.include)
+	if err != nil {
+		return "", fmt.Errorf("reading include %s: %w", cfg.include, err)
+	}
+
+	if cfg.includeShift != 0 {
+		included = shiftHeadings(included, cfg.includeShift)
+	}
+
+	return content + "\n" + included, nil
+}
+
+// shiftHeadings shifts the level of every ATX heading ("# "..."###### ") in
+// md by shift, clamping the result to the valid 1-6 range. Lines inside a
+// fenced (``` or ~~~) or 4-space-indented code block are left untouched, so
+// a "#"-prefixed shell/Python comment in a spliced snippet isn't mistaken
+// for a heading.
+func shiftHeadings(md string, shift int) string {
+	lines := strings.Split(md, "\n")
+	inFence := false
+	fenceMarker := ""
+
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+		indent := line[:len(line)-len(trimmed)]
+
+		if marker := fenceMarkerOf(trimmed); marker != "" {
+			switch {
+			case !inFence:
+				inFence = true
+				fenceMarker = marker
+			case marker[0] == fenceMarker[0] && len(marker) >= len(fenceMarker):
+				inFence = false
+				fenceMarker = ""
+			}
+			continue
+		}
+
+		if inFence || len(indent) >= 4 {
+			continue
+		}
+
+		level := 0
+		for level < len(trimmed) && level < 6 && trimmed[level] == '#' {
+			level++
+		}
+		if level == 0 || (level < len(trimmed) && trimmed[level] != ' ') {
+			continue
+		}
+
+		newLevel := level + shift
+		switch {
+		case newLevel < 1:
+			newLevel = 1
+		case newLevel > 6:
+			newLevel = 6
+		}
+		lines[i] = indent + strings.Repeat("#", newLevel) + trimmed[level:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// fenceMarkerOf returns the run of backticks or tildes opening or closing a
+// fenced code block if trimmed starts with at least 3 of either, else "".
+func fenceMarkerOf(trimmed string) string {
+	for _, ch := range [2]byte{'`', '~'} {
+		n := 0
+		for n < len(trimmed) && trimmed[n] == ch {
+			n++
+		}
+		if n >= 3 {
+			return trimmed[:n]
+		}
+	}
+	return ""
+}
+
 func readStdin() (string, error) {
 	input, err := io.ReadAll(os.Stdin)
 	if err != nil {
@@ -141,43 +648,350 @@ func fileContent(filename string) (string, error) {
 	return string(b), nil
 }
 
-func applyTemplate(tmpl, content string) (string, error) {
+func applyTemplate(tmpl string, data map[string]interface{}) (string, error) {
 	t, err := template.New("").Parse(tmpl)
 	if err != nil {
 		return "", err
 	}
 
 	var output bytes.Buffer
-	err = t.Execute(&output, map[string]interface{}{
-		"stdin": content,
-	})
-	if err != nil {
+	if err := t.Execute(&output, data); err != nil {
 		return "", err
 	}
 
 	return output.String(), nil
 }
 
-func injectContent(original, addition, startTag, endTag string) (string, error) {
+// buildTemplateContext assembles the data map passed to a template: the
+// piped-in content, user-supplied --var values, and auto-populated
+// .filename/.os/.now/.env/.git variables. .env and .git are only populated
+// when tmpl actually references them; .git is collected by shelling out to
+// git.
+func buildTemplateContext(cfg *config, tmpl, filename, content string) (map[string]interface{}, error) {
+	data := map[string]interface{}{
+		"stdin":    content,
+		"filename": filename,
+		"os":       runtime.GOOS,
+		"now":      time.Now().Format(time.RFC3339),
+		"var":      map[string]string(cfg.vars),
+	}
+
+	if templateReferences(tmpl, "env") {
+		data["env"] = envContext()
+	}
+
+	if templateReferences(tmpl, "git") {
+		git, err := gitContext()
+		if err != nil {
+			return nil, fmt.Errorf("collecting git metadata: %w", err)
+		}
+		data["git"] = git
+	}
+
+	return data, nil
+}
+
+// envContext returns the current environment as a map, for use as .env in
+// templates (e.g. .env.FOO).
+func envContext() map[string]string {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		key, value, ok := strings.Cut(kv, "=")
+		if ok {
+			env[key] = value
+		}
+	}
+	return env
+}
+
+// gitMetadata is exposed as .git in templates (e.g. .git.commit), with the
+// keys "commit" and "branch".
+type gitMetadata map[string]string
+
+func gitContext() (gitMetadata, error) {
+	commit, err := runGit("rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	branch, err := runGit("rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	return gitMetadata{"commit": commit, "branch": branch}, nil
+}
+
+func runGit(args ...string) (string, error) {
+	c := exec.Command("git", args...)
+	var out bytes.Buffer
+	c.Stdout = &out
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// templateReferences reports whether tmpl contains a field reference rooted
+// at key, e.g. templateReferences(tmpl, "git") matches ".git", ".git.commit",
+// and chained forms like "$.git.commit" or "$x.git", but not ".gitignore"
+// or a .var.git.
+func templateReferences(tmpl, key string) bool {
+	t, err := template.New("").Parse(tmpl)
+	if err != nil {
+		return false
+	}
+	return treeReferences(t.Tree, key)
+}
+
+func treeReferences(t *parse.Tree, key string) bool {
+	if t == nil {
+		return false
+	}
+	return nodeReferences(t.Root, key)
+}
+
+func nodeReferences(node parse.Node, key string) bool {
+	switch n := node.(type) {
+	case nil:
+		return false
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, c := range n.Nodes {
+			if nodeReferences(c, key) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return nodeReferences(n.Pipe, key)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			for _, arg := range cmd.Args {
+				if nodeReferences(arg, key) {
+					return true
+				}
+			}
+		}
+	case *parse.FieldNode:
+		return len(n.Ident) > 0 && n.Ident[0] == key
+	case *parse.ChainNode:
+		return len(n.Field) > 0 && n.Field[0] == key
+	case *parse.VariableNode:
+		// Ident[0] is the variable itself (e.g. "$" or "$x"); the field
+		// chain on it, if any, starts at Ident[1] (e.g. "$.git.commit" or
+		// "$x.git").
+		return len(n.Ident) > 1 && n.Ident[1] == key
+	case *parse.IfNode:
+		return nodeReferences(n.Pipe, key) || nodeReferences(n.List, key) || nodeReferences(n.ElseList, key)
+	case *parse.RangeNode:
+		return nodeReferences(n.Pipe, key) || nodeReferences(n.List, key) || nodeReferences(n.ElseList, key)
+	case *parse.WithNode:
+		return nodeReferences(n.Pipe, key) || nodeReferences(n.List, key) || nodeReferences(n.ElseList, key)
+	}
+	return false
+}
+
+// diffOp is one step of a line-level edit script: ' ' for unchanged, '-' for
+// a line only in the old content, '+' for a line only in the new content.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLine is a diffOp annotated with how many old/new lines preceded it,
+// which lets a hunk header be computed from any slice of the script without
+// re-walking it from the start.
+type diffLine struct {
+	kind      byte
+	text      string
+	beforeOld int
+	beforeNew int
+}
+
+// diffLines computes a line-level edit script between a and b via the
+// standard LCS dynamic-programming table, which takes O(len(a)*len(b)) time
+// and memory, with no external dependencies.
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+
+	return ops
+}
+
+// annotateDiff attaches beforeOld/beforeNew line counts to each op.
+func annotateDiff(ops []diffOp) []diffLine {
+	lines := make([]diffLine, len(ops))
+	oldCount, newCount := 0, 0
+	for i, op := range ops {
+		lines[i] = diffLine{kind: op.kind, text: op.line, beforeOld: oldCount, beforeNew: newCount}
+		switch op.kind {
+		case ' ':
+			oldCount++
+			newCount++
+		case '-':
+			oldCount++
+		case '+':
+			newCount++
+		}
+	}
+	return lines
+}
+
+// changeBlocks returns the [start, end) index ranges of maximal runs of
+// non-context ('-'/'+') lines.
+func changeBlocks(lines []diffLine) [][2]int {
+	var blocks [][2]int
+	i := 0
+	for i < len(lines) {
+		if lines[i].kind == ' ' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(lines) && lines[i].kind != ' ' {
+			i++
+		}
+		blocks = append(blocks, [2]int{start, i})
+	}
+	return blocks
+}
+
+// mergeHunkRanges expands each change block by diffContext lines of context
+// on either side, merging ranges that end up overlapping or adjacent.
+func mergeHunkRanges(blocks [][2]int, total, diffContext int) [][2]int {
+	var ranges [][2]int
+	for _, b := range blocks {
+		s := b[0] - diffContext
+		if s < 0 {
+			s = 0
+		}
+		e := b[1] + diffContext
+		if e > total {
+			e = total
+		}
+		if len(ranges) > 0 && s <= ranges[len(ranges)-1][1] {
+			ranges[len(ranges)-1][1] = e
+		} else {
+			ranges = append(ranges, [2]int{s, e})
+		}
+	}
+	return ranges
+}
+
+// unifiedDiff renders a `diff -u`-style unified diff between oldContent and
+// newContent, with 3 lines of context around each hunk.
+func unifiedDiff(filename, oldContent, newContent string) string {
+	const contextLines = 3
+
+	lines := annotateDiff(diffLines(splitLines(oldContent), splitLines(newContent)))
+
+	blocks := changeBlocks(lines)
+	if len(blocks) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- a/%s\n+++ b/%s\n", filename, filename)
+
+	for _, r := range mergeHunkRanges(blocks, len(lines), contextLines) {
+		hunk := lines[r[0]:r[1]]
+
+		oldCount, newCount := 0, 0
+		for _, l := range hunk {
+			if l.kind != '+' {
+				oldCount++
+			}
+			if l.kind != '-' {
+				newCount++
+			}
+		}
+
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", hunk[0].beforeOld+1, oldCount, hunk[0].beforeNew+1, newCount)
+		for _, l := range hunk {
+			out.WriteByte(l.kind)
+			out.WriteString(l.text)
+			out.WriteByte('\n')
+		}
+	}
+
+	return out.String()
+}
+
+// splitLines splits s into lines, dropping the single trailing empty
+// element a terminating "\n" would otherwise produce.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func injectContent(original, addition string, tags tagPair) (string, error) {
 	// look for opening/closing tags
-	startTagPos := strings.Index(original, startTag)
-	endTagPos := strings.Index(original, endTag)
+	startTagPos := strings.Index(original, tags.Start)
+	endTagPos := strings.Index(original, tags.End)
 
 	// both tags missing - append the content
 	if startTagPos < 0 && endTagPos < 0 {
-		return fmt.Sprintf("%s\n%s\n%s\n%s\n", original, startTag, addition, endTag), nil
+		return fmt.Sprintf("%s\n%s\n%s\n%s\n", original, tags.Start, addition, tags.End), nil
 	}
 
 	if startTagPos < 0 {
-		return "", fmt.Errorf("missing start tag %s while end tag is present", startTag)
+		return "", fmt.Errorf("missing start tag %s while end tag is present", tags.Start)
 	}
 	if endTagPos < 0 {
-		return "", fmt.Errorf("missing end tag %s while start tag is present", endTag)
+		return "", fmt.Errorf("missing end tag %s while start tag is present", tags.End)
 	}
-	if startTagPos > startTagPos {
+	if startTagPos > endTagPos {
 		return "", fmt.Errorf("end tag is before the start tag")
 	}
 
 	// both tags are found where they should - inject content
-	return fmt.Sprintf("%s\n%s\n%s", original[:startTagPos+len(startTag)], addition, original[endTagPos:]), nil
+	return fmt.Sprintf("%s\n%s\n%s", original[:startTagPos+len(tags.Start)], addition, original[endTagPos:]), nil
 }
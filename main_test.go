@@ -0,0 +1,605 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := `- id: usage
+  file: README.md
+  command: echo hi
+  template: "{{ .stdin }}"
+- id: toc
+  file: docs/index.md
+  command: './gen-toc.sh'
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := parseConfigFile(path)
+	if err != nil {
+		t.Fatalf("parseConfigFile: %v", err)
+	}
+
+	want := []batchEntry{
+		{ID: "usage", File: "README.md", Command: "echo hi", Template: "{{ .stdin }}"},
+		{ID: "toc", File: "docs/index.md", Command: "./gen-toc.sh"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got %+v, want %+v", entries, want)
+	}
+}
+
+func TestParseConfigFileMissingCommand(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("- id: usage\n  file: README.md\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parseConfigFile(path); err == nil {
+		t.Fatal("expected an error for an entry missing \"command\"")
+	}
+}
+
+func TestParseConfigFileUnknownKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("- id: usage\n  bogus: x\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parseConfigFile(path); err == nil {
+		t.Fatal("expected an error for an unknown key")
+	}
+}
+
+func TestParseConfigFileValueOutsideListItem(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("id: usage\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parseConfigFile(path); err == nil {
+		t.Fatal("expected an error for a key/value line before any \"- \" item")
+	}
+}
+
+func TestSplitConfigKV(t *testing.T) {
+	cases := []struct {
+		in, key, value string
+	}{
+		{"id: usage", "id", "usage"},
+		{`template: "{{ .stdin }}"`, "template", "{{ .stdin }}"},
+		{"command: './gen-toc.sh'", "command", "./gen-toc.sh"},
+		{"file:README.md", "file", "README.md"},
+	}
+	for _, c := range cases {
+		key, value, ok := splitConfigKV(c.in)
+		if !ok || key != c.key || value != c.value {
+			t.Errorf("splitConfigKV(%q) = (%q, %q, %v), want (%q, %q, true)", c.in, key, value, ok, c.key, c.value)
+		}
+	}
+
+	if _, _, ok := splitConfigKV("not a kv line"); ok {
+		t.Error("splitConfigKV of a line with no colon should report ok=false")
+	}
+}
+
+func TestFileLocksOnePerDistinctFile(t *testing.T) {
+	entries := []batchEntry{{File: "a.md"}, {File: "a.md"}, {File: "b.md"}}
+	locks := fileLocks(entries)
+
+	if len(locks) != 2 {
+		t.Fatalf("got %d locks, want 2", len(locks))
+	}
+	if locks["a.md"] == nil || locks["b.md"] == nil {
+		t.Fatal("expected a lock for each distinct file")
+	}
+}
+
+// TestRunBatchSameFileSerialized exercises two config entries targeting the
+// same file: without per-file locking, the second entry's read-modify-write
+// could race the first's and silently drop its block.
+func TestRunBatchSameFileSerialized(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "out.md")
+	if err := os.WriteFile(target, []byte("# Doc\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	configPath := filepath.Join(dir, "config.yaml")
+	yamlConfig := fmt.Sprintf("- id: a\n  file: %s\n  command: printf alpha\n- id: b\n  file: %s\n  command: printf beta\n", target, target)
+	if err := os.WriteFile(configPath, []byte(yamlConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config{configFile: configPath, template: "{{ .stdin }}", vars: templateVars{}}
+	if code, err := runBatch(cfg); err != nil {
+		t.Fatalf("runBatch: %v (code=%d)", err, code)
+	}
+
+	out, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"<!-- START md-inject:a -->\nalpha\n<!-- END md-inject:a -->",
+		"<!-- START md-inject:b -->\nbeta\n<!-- END md-inject:b -->",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("output missing block %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestRunBatchFailOnDiffAggregatesAcrossEntries(t *testing.T) {
+	dir := t.TempDir()
+	unchanged := filepath.Join(dir, "unchanged.md")
+	changed := filepath.Join(dir, "changed.md")
+	if err := os.WriteFile(unchanged, []byte("<!-- START md-inject:a -->\nsame\n<!-- END md-inject:a -->\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(changed, []byte("<!-- START md-inject:b -->\nold\n<!-- END md-inject:b -->\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	configPath := filepath.Join(dir, "config.yaml")
+	yamlConfig := fmt.Sprintf("- id: a\n  file: %s\n  command: printf same\n- id: b\n  file: %s\n  command: echo new\n", unchanged, changed)
+	if err := os.WriteFile(configPath, []byte(yamlConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config{configFile: configPath, template: "{{ .stdin }}", vars: templateVars{}, failOnDiff: true}
+	code, err := runBatch(cfg)
+	if err == nil || code != 2 {
+		t.Fatalf("got code=%d, err=%v; want code=2 and a non-nil error", code, err)
+	}
+
+	// --fail-on-diff must not have written the changed file.
+	out, err := os.ReadFile(changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "old") {
+		t.Errorf("changed.md was written despite --fail-on-diff: %s", out)
+	}
+}
+
+func TestDiffLinesBasic(t *testing.T) {
+	ops := diffLines([]string{"a", "b", "c"}, []string{"a", "x", "c"})
+
+	var got []string
+	for _, op := range ops {
+		got = append(got, string(op.kind)+op.line)
+	}
+
+	want := []string{" a", "-b", "+x", " c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestUnifiedDiffNoChange(t *testing.T) {
+	if diff := unifiedDiff("f.md", "same\n", "same\n"); diff != "" {
+		t.Errorf("expected an empty diff for identical content, got %q", diff)
+	}
+}
+
+func TestUnifiedDiffSingleHunk(t *testing.T) {
+	old := "a\nb\nc\n"
+	updated := "a\nX\nc\n"
+
+	got := unifiedDiff("f.md", old, updated)
+	want := "--- a/f.md\n+++ b/f.md\n@@ -1,3 +1,3 @@\n a\n-b\n+X\n c\n"
+	if got != want {
+		t.Errorf("got:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+// TestUnifiedDiffMergesNearbyHunks checks that two changes close enough
+// together (within 2*contextLines of each other) are rendered as a single
+// merged hunk rather than two overlapping ones.
+func TestUnifiedDiffMergesNearbyHunks(t *testing.T) {
+	oldLines := make([]string, 20)
+	newLines := make([]string, 20)
+	for i := range oldLines {
+		oldLines[i] = fmt.Sprintf("line%d", i+1)
+		newLines[i] = oldLines[i]
+	}
+	newLines[2] = "CHANGED"  // index 2
+	newLines[8] = "CHANGED2" // index 8, 5 lines of context between the two changes
+
+	diff := unifiedDiff("f.md", joinLines(oldLines), joinLines(newLines))
+	if n := strings.Count(diff, "@@"); n != 2 {
+		t.Errorf("expected one merged hunk (2 '@@' markers), got %d in:\n%s", n, diff)
+	}
+}
+
+// TestUnifiedDiffKeepsDistantHunksSeparate is the contrasting case: changes
+// far enough apart must stay as two independent hunks.
+func TestUnifiedDiffKeepsDistantHunksSeparate(t *testing.T) {
+	oldLines := make([]string, 30)
+	newLines := make([]string, 30)
+	for i := range oldLines {
+		oldLines[i] = fmt.Sprintf("line%d", i+1)
+		newLines[i] = oldLines[i]
+	}
+	newLines[2] = "CHANGED"
+	newLines[25] = "CHANGED2"
+
+	diff := unifiedDiff("f.md", joinLines(oldLines), joinLines(newLines))
+	if n := strings.Count(diff, "@@"); n != 4 {
+		t.Errorf("expected two separate hunks (4 '@@' markers), got %d in:\n%s", n, diff)
+	}
+}
+
+func joinLines(lines []string) string {
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestTemplateReferences(t *testing.T) {
+	cases := []struct {
+		name string
+		tmpl string
+		key  string
+		want bool
+	}{
+		{"direct field", "{{ .git }}", "git", true},
+		{"nested field", "{{ .git.commit }}", "git", true},
+		{"dollar chain", "{{ $.git.commit }}", "git", true},
+		{"variable chain", "{{ $x := . }}{{ $x.git }}", "git", true},
+		{"inside if", "{{ if .var.flag }}{{ .git.branch }}{{ end }}", "git", true},
+		{"inside range", "{{ range .items }}{{ .git }}{{ end }}", "git", true},
+		{"inside with", "{{ with .git }}{{ .commit }}{{ end }}", "git", true},
+		{"unrelated field with shared prefix", "{{ .gitignore }}", "git", false},
+		{"key nested under a different root", "{{ .var.git }}", "git", false},
+		{"no reference at all", "{{ .stdin }}", "git", false},
+		{"different key, env", "{{ .env.HOME }}", "env", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := templateReferences(c.tmpl, c.key); got != c.want {
+				t.Errorf("templateReferences(%q, %q) = %v, want %v", c.tmpl, c.key, got, c.want)
+			}
+		})
+	}
+}
+
+func TestBuildTemplateContextSkipsGitAndEnvWhenUnreferenced(t *testing.T) {
+	cfg := &config{vars: templateVars{}}
+
+	data, err := buildTemplateContext(cfg, "{{ .stdin }}", "file.md", "content")
+	if err != nil {
+		t.Fatalf("buildTemplateContext: %v", err)
+	}
+	if _, ok := data["git"]; ok {
+		t.Error("git metadata was collected despite the template not referencing .git")
+	}
+	if _, ok := data["env"]; ok {
+		t.Error("env was populated despite the template not referencing .env")
+	}
+}
+
+func TestBuildTemplateContextPopulatesGitWhenReferenced(t *testing.T) {
+	cfg := &config{vars: templateVars{}}
+
+	data, err := buildTemplateContext(cfg, "{{ $.git.commit }}", "file.md", "content")
+	if err != nil {
+		t.Fatalf("buildTemplateContext: %v", err)
+	}
+
+	git, ok := data["git"].(gitMetadata)
+	if !ok {
+		t.Fatal("expected .git to be populated as gitMetadata")
+	}
+	if git["commit"] == "" {
+		t.Error("expected a non-empty commit hash")
+	}
+}
+
+func TestBuildTemplateContextPopulatesEnvWhenReferenced(t *testing.T) {
+	t.Setenv("MD_INJECT_TEST_VAR", "hello")
+	cfg := &config{vars: templateVars{}}
+
+	data, err := buildTemplateContext(cfg, "{{ .env.MD_INJECT_TEST_VAR }}", "file.md", "content")
+	if err != nil {
+		t.Fatalf("buildTemplateContext: %v", err)
+	}
+
+	env, ok := data["env"].(map[string]string)
+	if !ok {
+		t.Fatal("expected .env to be populated as map[string]string")
+	}
+	if env["MD_INJECT_TEST_VAR"] != "hello" {
+		t.Errorf("got %q, want %q", env["MD_INJECT_TEST_VAR"], "hello")
+	}
+}
+
+func TestResolveContentPrefersSourceCmd(t *testing.T) {
+	cfg := &config{sourceCmd: "printf cmd-output", sourceFile: "should-be-ignored.txt"}
+	got, err := resolveContent(cfg)
+	if err != nil || got != "cmd-output" {
+		t.Fatalf("got (%q, %v), want (\"cmd-output\", nil)", got, err)
+	}
+}
+
+func TestResolveContentFallsBackToSourceFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "src.txt")
+	if err := os.WriteFile(path, []byte("file-output"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config{sourceFile: path}
+	got, err := resolveContent(cfg)
+	if err != nil || got != "file-output" {
+		t.Fatalf("got (%q, %v), want (\"file-output\", nil)", got, err)
+	}
+}
+
+func TestResolveContentFallsBackToStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = old }()
+
+	go func() {
+		w.WriteString("stdin-output")
+		w.Close()
+	}()
+
+	cfg := &config{}
+	got, err := resolveContent(cfg)
+	if err != nil || got != "stdin-output" {
+		t.Fatalf("got (%q, %v), want (\"stdin-output\", nil)", got, err)
+	}
+}
+
+func TestResolveContentSourceCmdFailure(t *testing.T) {
+	cfg := &config{sourceCmd: "exit 1"}
+	if _, err := resolveContent(cfg); err == nil {
+		t.Fatal("expected an error when --source-cmd exits non-zero")
+	}
+}
+
+func TestResolveContentAllowSourceFail(t *testing.T) {
+	cfg := &config{sourceCmd: "printf partial; exit 1", allowSourceFail: true}
+	got, err := resolveContent(cfg)
+	if err != nil {
+		t.Fatalf("expected --allow-source-fail to suppress the error, got %v", err)
+	}
+	if got != "partial" {
+		t.Errorf("got %q, want %q", got, "partial")
+	}
+}
+
+func TestApplyIncludeNoop(t *testing.T) {
+	cfg := &config{}
+	got, err := applyInclude(cfg, "base")
+	if err != nil || got != "base" {
+		t.Fatalf("got (%q, %v), want (\"base\", nil)", got, err)
+	}
+}
+
+func TestApplyIncludeSplicesAndShiftsHeadings(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "footer.md")
+	if err := os.WriteFile(path, []byte("# Sub\nbody\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config{include: path, includeShift: 1}
+	got, err := applyInclude(cfg, "base")
+	if err != nil {
+		t.Fatalf("applyInclude: %v", err)
+	}
+
+	want := "base\n## Sub\nbody\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestApplyIncludeMissingFile(t *testing.T) {
+	cfg := &config{include: "/does/not/exist.md"}
+	if _, err := applyInclude(cfg, "base"); err == nil {
+		t.Fatal("expected an error for a missing --include file")
+	}
+}
+
+func TestShiftHeadings(t *testing.T) {
+	cases := []struct {
+		name  string
+		md    string
+		shift int
+		want  string
+	}{
+		{
+			name:  "simple heading shift",
+			md:    "# Title\ntext\n",
+			shift: 1,
+			want:  "## Title\ntext\n",
+		},
+		{
+			name:  "clamped to level 6",
+			md:    "###### Deep\n",
+			shift: 3,
+			want:  "###### Deep\n",
+		},
+		{
+			name:  "clamped to level 1",
+			md:    "## Sub\n",
+			shift: -5,
+			want:  "# Sub\n",
+		},
+		{
+			name:  "fenced backtick block untouched",
+			md:    "# Title\n```sh\n# comment\n```\n",
+			shift: 1,
+			want:  "## Title\n```sh\n# comment\n```\n",
+		},
+		{
+			name:  "fenced tilde block untouched",
+			md:    "# Title\n~~~\n# not a heading\n~~~\n",
+			shift: 1,
+			want:  "## Title\n~~~\n# not a heading\n~~~\n",
+		},
+		{
+			name:  "heading after a closed fence is still shifted",
+			md:    "```\n# not a heading\n```\n# Title\n",
+			shift: 1,
+			want:  "```\n# not a heading\n```\n## Title\n",
+		},
+		{
+			name:  "indented code block untouched",
+			md:    "# Title\n    # indented comment\n",
+			shift: 1,
+			want:  "## Title\n    # indented comment\n",
+		},
+		{
+			name:  "not a heading without trailing space",
+			md:    "#no-space\n",
+			shift: 1,
+			want:  "#no-space\n",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := shiftHeadings(c.md, c.shift); got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestBuildTemplateContextVarAndBuiltins(t *testing.T) {
+	cfg := &config{vars: templateVars{"foo": "bar"}}
+
+	data, err := buildTemplateContext(cfg, "{{ .var.foo }} {{ .filename }} {{ .os }}", "file.md", "content")
+	if err != nil {
+		t.Fatalf("buildTemplateContext: %v", err)
+	}
+
+	if vars, ok := data["var"].(map[string]string); !ok || vars["foo"] != "bar" {
+		t.Errorf("got .var = %#v, want map with foo=bar", data["var"])
+	}
+	if data["filename"] != "file.md" {
+		t.Errorf("got .filename = %#v, want %q", data["filename"], "file.md")
+	}
+	if data["os"] != runtime.GOOS {
+		t.Errorf("got .os = %#v, want %q", data["os"], runtime.GOOS)
+	}
+}
+
+func TestResolveTagPair(t *testing.T) {
+	cases := []struct {
+		name      string
+		cfg       *config
+		filename  string
+		wantStart string
+		wantEnd   string
+		wantErr   bool
+	}{
+		{
+			name:      "default html for markdown",
+			cfg:       &config{},
+			filename:  "readme.md",
+			wantStart: "<!-- START md-inject:id1 -->",
+			wantEnd:   "<!-- END md-inject:id1 -->",
+		},
+		{
+			name:      "auto-detect hash for yaml",
+			cfg:       &config{},
+			filename:  "config.yaml",
+			wantStart: "# START md-inject:id1",
+			wantEnd:   "# END md-inject:id1",
+		},
+		{
+			name:      "auto-detect slashstar for c",
+			cfg:       &config{},
+			filename:  "main.c",
+			wantStart: "/* START md-inject:id1 */",
+			wantEnd:   "/* END md-inject:id1 */",
+		},
+		{
+			name:      "unknown extension falls back to html",
+			cfg:       &config{},
+			filename:  "Makefile",
+			wantStart: "<!-- START md-inject:id1 -->",
+			wantEnd:   "<!-- END md-inject:id1 -->",
+		},
+		{
+			name:      "explicit comment-style overrides extension",
+			cfg:       &config{commentStyle: "semicolon"},
+			filename:  "readme.md",
+			wantStart: "; START md-inject:id1",
+			wantEnd:   "; END md-inject:id1",
+		},
+		{
+			name:     "unknown comment-style errors",
+			cfg:      &config{commentStyle: "bogus"},
+			filename: "readme.md",
+			wantErr:  true,
+		},
+		{
+			name:      "tag-format overrides comment-style and extension",
+			cfg:       &config{tagFormat: "<<%s|>>%s", commentStyle: "hash"},
+			filename:  "readme.md",
+			wantStart: "<<id1",
+			wantEnd:   ">>id1",
+		},
+		{
+			name:     "tag-format missing both placeholders errors",
+			cfg:      &config{tagFormat: "FOO|BAR"},
+			filename: "readme.md",
+			wantErr:  true,
+		},
+		{
+			name:     "tag-format missing the second placeholder errors",
+			cfg:      &config{tagFormat: "FOO %s|BAR"},
+			filename: "readme.md",
+			wantErr:  true,
+		},
+		{
+			name:     "tag-format missing the separator errors",
+			cfg:      &config{tagFormat: "FOO %s BAR %s"},
+			filename: "readme.md",
+			wantErr:  true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tags, err := resolveTagPair(c.cfg, "id1", c.filename)
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveTagPair: %v", err)
+			}
+			if tags.Start != c.wantStart || tags.End != c.wantEnd {
+				t.Errorf("got {%q, %q}, want {%q, %q}", tags.Start, tags.End, c.wantStart, c.wantEnd)
+			}
+		})
+	}
+}